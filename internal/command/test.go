@@ -2,12 +2,19 @@ package command
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+
 	"github.com/hashicorp/terraform/internal/addrs"
 	"github.com/hashicorp/terraform/internal/backend"
 	"github.com/hashicorp/terraform/internal/command/arguments"
@@ -15,7 +22,9 @@ import (
 	"github.com/hashicorp/terraform/internal/configs"
 	"github.com/hashicorp/terraform/internal/logging"
 	"github.com/hashicorp/terraform/internal/moduletest"
+	"github.com/hashicorp/terraform/internal/moduletest/mocking"
 	"github.com/hashicorp/terraform/internal/plans"
+	"github.com/hashicorp/terraform/internal/providers"
 	"github.com/hashicorp/terraform/internal/states"
 	"github.com/hashicorp/terraform/internal/terraform"
 	"github.com/hashicorp/terraform/internal/tfdiags"
@@ -50,7 +59,28 @@ Options:
   -json                 If specified, machine readable output will be printed in
                         JSON format
 
-  -test-directory=path	Set the Terraform test directory, defaults to "tests".    
+  -junit-xml=path       Write a JUnit XML report of the executed run blocks to
+                        the given path, for consumption by CI systems such as
+                        Jenkins, GitLab, and CircleCI. Can be combined with
+                        -json but not with -tap.
+
+  -parallelism=N        Set the number of test files that are allowed to execute
+                        concurrently, for files that have opted in with
+                        "parallel = true". Defaults to 10.
+
+  -tap                  Print a TAP (Test Anything Protocol) v13 stream of the
+                        executed run blocks to stdout, for consumption by CI
+                        systems that understand TAP. Cannot be combined with
+                        -json or -junit-xml.
+
+  -test-directory=path	Set the Terraform test directory, defaults to "tests".
+
+  -test-var-file=filename  Load variable values from the given JSON file. Unlike
+                        -var-file, the value for each key is parsed as JSON, so
+                        complex object, tuple, and null values round-trip
+                        losslessly from external tooling without needing to be
+                        re-encoded as HCL. Use this option more than once to
+                        include more than one file.
 
   -var 'foo=bar'        Set a value for one of the input variables in the root
                         module of the configuration. Use this option more than
@@ -71,6 +101,22 @@ func (c *TestCommand) Synopsis() string {
 	return "Execute integration tests for Terraform modules"
 }
 
+// validateTestOutputOptions enforces the output option constraints documented
+// in Help(): -tap is its own report format and can't be combined with -json
+// or -junit-xml, though -junit-xml and -json can be combined with each
+// other.
+func validateTestOutputOptions(viewType arguments.ViewType, tap bool, junitXMLFile string) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+	if tap && (viewType == arguments.ViewJSON || junitXMLFile != "") {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Incompatible output options",
+			"The -tap option cannot be combined with -json or -junit-xml.",
+		))
+	}
+	return diags
+}
+
 func (c *TestCommand) Run(rawArgs []string) int {
 	var diags tfdiags.Diagnostics
 
@@ -84,7 +130,23 @@ func (c *TestCommand) Run(rawArgs []string) int {
 		return 1
 	}
 
-	view := views.NewTest(args.ViewType, c.View)
+	// -tap can't be combined with -json or -junit-xml: it's its own report
+	// format, not a layer on top of the other two the way -junit-xml is.
+	if outputDiags := validateTestOutputOptions(args.ViewType, args.TAP, args.JUnitXMLFile); outputDiags.HasErrors() {
+		diags = diags.Append(outputDiags)
+		c.View.Diagnostics(diags)
+		c.View.HelpPrompt("test")
+		return 1
+	}
+
+	// -junit-xml and -tap are passed through as options rather than as
+	// another arguments.ViewType: they accumulate the same per-run results
+	// the human/JSON view receives, just render them as a report on
+	// Conclusion instead of streaming them as they happen.
+	view := views.NewTest(args.ViewType, c.View, views.TestViewOpts{
+		JUnitXMLFile: args.JUnitXMLFile,
+		TAP:          args.TAP,
+	})
 
 	config, configDiags := c.loadConfigWithTests(".", args.TestDirectory)
 	diags = diags.Append(configDiags)
@@ -174,6 +236,40 @@ func (c *TestCommand) Run(rawArgs []string) int {
 		return 1
 	}
 
+	// -test-var-file values sit alongside the -var/-var-file globals above,
+	// but only fill in names that a -var/-var-file didn't already set, since
+	// those are always given directly on the command line. protected
+	// records just those pre-existing names, so that among the
+	// -test-var-file files themselves a later file still wins over an
+	// earlier one, matching -var-file's own "later wins" convention. Names
+	// set only by a TF_VAR_ environment variable aren't protected, since
+	// those rank below a -test-var-file.
+	protected := make(map[string]struct{}, len(variables))
+	for name, variable := range variables {
+		if v, ok := variable.(unparsedVariableValueString); ok && v.sourceType == terraform.ValueFromEnvVar {
+			continue
+		}
+		protected[name] = struct{}{}
+	}
+	for _, path := range args.TestVarFiles {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to read JSON variables file",
+				fmt.Sprintf("Terraform could not read %s: %s.", path, err)))
+			continue
+		}
+
+		fileVariables, fileDiags := parseVariablesJSON(path, raw)
+		diags = diags.Append(fileDiags)
+		mergeTestVarFileVariables(variables, protected, fileVariables)
+	}
+	if diags.HasErrors() {
+		view.Diagnostics(nil, nil, diags)
+		return 1
+	}
+
 	// We have two levels of interrupt here. A 'stop' and a 'cancel'. A 'stop'
 	// is a soft request to stop. We'll finish the current test, do the tidy up,
 	// but then skip all remaining tests and run blocks. A 'cancel' is a hard
@@ -193,6 +289,8 @@ func (c *TestCommand) Run(rawArgs []string) int {
 		Config: config,
 		View:   view,
 
+		TestDirectory: args.TestDirectory,
+
 		CancelledCtx: cancelCtx,
 		StoppedCtx:   stopCtx,
 
@@ -202,6 +300,8 @@ func (c *TestCommand) Run(rawArgs []string) int {
 		Stopped:   false,
 
 		Verbose: args.Verbose,
+
+		Parallelism: args.Parallelism,
 	}
 
 	view.Abstract(&suite)
@@ -270,6 +370,16 @@ type TestRunner struct {
 
 	View views.Test
 
+	// TestDirectory is the directory Terraform searched for test files in,
+	// used to auto-load terraform.tfvars/*.auto.tfvars files that sit
+	// alongside them.
+	TestDirectory string
+
+	// variableLayersMu guards variableLayers, since files running in
+	// parallel may resolve their auto-loaded variable layers concurrently.
+	variableLayersMu sync.Mutex
+	variableLayers   map[string]map[string]backend.UnparsedVariableValue
+
 	// Stopped and Cancelled track whether the user requested the testing
 	// process to be interrupted. Stopped is a nice graceful exit, we'll still
 	// tidy up any state that was created and mark the tests with relevant
@@ -288,6 +398,12 @@ type TestRunner struct {
 
 	// Verbose tells the runner to print out plan files during each test run.
 	Verbose bool
+
+	// Parallelism caps how many test files that have opted in with
+	// `parallel = true` may execute at once. Files that don't opt in always
+	// run sequentially, exactly as they always have. A value less than 1 is
+	// treated as 1.
+	Parallelism int
 }
 
 func (runner *TestRunner) Start(globals map[string]backend.UnparsedVariableValue) {
@@ -298,21 +414,96 @@ func (runner *TestRunner) Start(globals map[string]backend.UnparsedVariableValue
 	sort.Strings(files) // execute the files in alphabetical order
 
 	runner.Suite.Status = moduletest.Pass
+
+	// mergeStatus folds a finished file's status into the suite as a whole.
+	// It's called from both the sequential loop below and from parallel
+	// workers, so it takes a lock to stay safe under concurrent use.
+	var statusMu sync.Mutex
+	mergeStatus := func(status moduletest.Status) {
+		statusMu.Lock()
+		defer statusMu.Unlock()
+		runner.Suite.Status = runner.Suite.Status.Merge(status)
+	}
+
+	var parallel, sequential []string
 	for _, name := range files {
+		if runner.Suite.Files[name].Config.Parallel {
+			parallel = append(parallel, name)
+		} else {
+			sequential = append(sequential, name)
+		}
+	}
+
+	if len(parallel) > 0 {
+		parallelism := runner.Parallelism
+		if parallelism < 1 {
+			parallelism = 1
+		}
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, parallelism)
+		for _, name := range parallel {
+			if runner.Cancelled {
+				break
+			}
+
+			file := runner.Suite.Files[name]
+
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				// execute() mutates the root *configs.Config in place for the
+				// duration of a run (via config.TransformForTest) and undoes it
+				// again afterwards. Sharing runner.Config across files that are
+				// now genuinely running concurrently would let one file's
+				// transform/reset pair race with another's, so each worker loads
+				// and plans/applies against its own independently loaded config
+				// instead.
+				config, configDiags := runner.command.loadConfigWithTests(".", runner.TestDirectory)
+				if configDiags.HasErrors() {
+					file.Status = file.Status.Merge(moduletest.Error)
+					runner.View.Diagnostics(nil, file, configDiags)
+					mergeStatus(file.Status)
+					return
+				}
+
+				// Each worker gets its own buffered view so concurrently
+				// executing files can't interleave their output; the
+				// buffer is flushed to the real view once the file is done.
+				view := newBufferedTestView(runner.View)
+				runner.ExecuteTestFile(file, globals, view, config)
+				view.Flush()
+
+				mergeStatus(file.Status)
+			}()
+		}
+		wg.Wait()
+	}
+
+	// Files that didn't opt into parallel execution still run one at a time,
+	// in alphabetical order, exactly as they always have.
+	for _, name := range sequential {
 		if runner.Cancelled {
 			return
 		}
 
 		file := runner.Suite.Files[name]
-		runner.ExecuteTestFile(file, globals)
-		runner.Suite.Status = runner.Suite.Status.Merge(file.Status)
+		runner.ExecuteTestFile(file, globals, runner.View, runner.Config)
+		mergeStatus(file.Status)
 	}
 }
 
-func (runner *TestRunner) ExecuteTestFile(file *moduletest.File, globals map[string]backend.UnparsedVariableValue) {
-	mgr := new(TestStateManager)
-	mgr.runner = runner
-	mgr.State = states.NewState()
+// ExecuteTestFile runs every run block in file in order against config,
+// which is the root configuration to use for any run block that doesn't
+// specify its own ConfigUnderTest. Sequential files share runner.Config,
+// but each file running as part of a parallel batch is given its own
+// independently loaded config, since execute() mutates it in place for the
+// duration of a run.
+func (runner *TestRunner) ExecuteTestFile(file *moduletest.File, globals map[string]backend.UnparsedVariableValue, view views.Test, config *configs.Config) {
+	mgr := newTestStateManager(runner, view, config)
 	defer mgr.cleanupStates(file, globals)
 
 	file.Status = file.Status.Merge(moduletest.Pass)
@@ -343,23 +534,49 @@ func (runner *TestRunner) ExecuteTestFile(file *moduletest.File, globals map[str
 			// Then we want to execute a different module under a kind of
 			// sandbox.
 			state := runner.ExecuteTestRun(mgr, run, file, states.NewState(), run.Config.ConfigUnderTest, globals)
-			mgr.States = append(mgr.States, &TestModuleState{
+			mgr.ModuleStates = append(mgr.ModuleStates, &TestModuleState{
 				State: state,
 				Run:   run,
 			})
 		} else {
-			mgr.State = runner.ExecuteTestRun(mgr, run, file, mgr.State, runner.Config, globals)
+			// The run block is targeting one of the named state scopes for
+			// this file (or the main, unnamed scope). ExecuteTestRun looks up
+			// the right prior state for us and records the result back under
+			// the same key.
+			runner.ExecuteTestRun(mgr, run, file, nil, config, globals)
 		}
 		file.Status = file.Status.Merge(run.Status)
 	}
 
-	runner.View.File(file)
+	mgr.view.File(file)
 	for _, run := range file.Runs {
-		runner.View.Run(run, file)
+		mgr.view.Run(run, file)
 	}
 }
 
+// applyExpectedDiagnostics runs validateFailures and then validateWarnings
+// over diags, in that order, so that a diagnostic expect_failures already
+// claimed isn't also reported as an unexpected warning by
+// validateWarnings. Taking the two validators as parameters, rather than
+// calling run.ValidateExpectedFailures/run.ValidateExpectedWarnings
+// directly, lets this ordering be tested without a fully constructed
+// *moduletest.Run.
+func applyExpectedDiagnostics(diags tfdiags.Diagnostics, validateFailures, validateWarnings func(tfdiags.Diagnostics) tfdiags.Diagnostics) tfdiags.Diagnostics {
+	diags = validateFailures(diags)
+	diags = validateWarnings(diags)
+	return diags
+}
+
 func (runner *TestRunner) ExecuteTestRun(mgr *TestStateManager, run *moduletest.Run, file *moduletest.File, state *states.State, config *configs.Config, globals map[string]backend.UnparsedVariableValue) *states.State {
+	// A nil state means the caller wants us to run against one of the file's
+	// named state scopes (the "" key is the main, unnamed scope) rather than
+	// a one-off state for a run block under a different module.
+	key := stateKeyForRun(run)
+	usingKeyedState := state == nil
+	if usingKeyedState {
+		state = mgr.stateForKey(key)
+	}
+
 	if runner.Cancelled {
 		// Don't do anything, just give up and return immediately.
 		// The surrounding functions should stop this even being called, but in
@@ -387,6 +604,10 @@ func (runner *TestRunner) ExecuteTestRun(mgr *TestStateManager, run *moduletest.
 		return state
 	}
 
+	// Timing is recorded around the plan/apply cycle only, since that's the
+	// part of a run block's execution CI report formats like JUnit XML and
+	// TAP expect to see reflected in a testcase's duration.
+	start := time.Now()
 	ctx, plan, state, diags := runner.execute(mgr, run, file, config, state, &terraform.PlanOpts{
 		Mode: func() plans.Mode {
 			switch run.Config.Options.Mode {
@@ -401,13 +622,25 @@ func (runner *TestRunner) ExecuteTestRun(mgr *TestStateManager, run *moduletest.
 		SkipRefresh:        !run.Config.Options.Refresh,
 		ExternalReferences: references,
 	}, run.Config.Command, globals)
-	diags = run.ValidateExpectedFailures(diags)
+	run.Duration = time.Since(start)
+	if usingKeyedState {
+		mgr.setStateForKey(key, state)
+	}
+	// run.ValidateExpectedFailures and run.ValidateExpectedWarnings both pair
+	// each diagnostic against the specific resource/output/check rule the
+	// user named in expect_failures / expect_warnings (walking the
+	// addrs.DiagnosticOriginatesFromCheckRule metadata that's already
+	// threaded through diagnostics by the check subsystem), rather than the
+	// previous all-or-nothing match against an address alone. Failures are
+	// validated first so a diagnostic expected as a failure can't also be
+	// reported as an unexpected warning.
+	diags = applyExpectedDiagnostics(diags, run.ValidateExpectedFailures, run.ValidateExpectedWarnings)
 	run.Diagnostics = run.Diagnostics.Append(diags)
 
 	if runner.Cancelled {
 		// Print out the diagnostics from the run now, since it was cancelled
 		// the normal set of diagnostics will not be printed otherwise.
-		runner.View.Diagnostics(run, file, run.Diagnostics)
+		mgr.view.Diagnostics(run, file, run.Diagnostics)
 		run.Status = moduletest.Error
 		return state
 	}
@@ -449,7 +682,7 @@ func (runner *TestRunner) ExecuteTestRun(mgr *TestStateManager, run *moduletest.
 		run.Diagnostics = run.Diagnostics.Append(diags)
 	}
 
-	variables, diags := buildInputVariablesForAssertions(run, file, config, globals)
+	variables, diags := buildInputVariablesForAssertions(runner, run, file, config, globals)
 	run.Diagnostics = run.Diagnostics.Append(diags)
 	if diags.HasErrors() {
 		run.Status = moduletest.Error
@@ -465,6 +698,79 @@ func (runner *TestRunner) ExecuteTestRun(mgr *TestStateManager, run *moduletest.
 	return state
 }
 
+// mockProviderFactories returns a providers.Factory for every provider that
+// the run block and/or the file has asked to mock out via a mock_provider
+// block. Substituting these into the context options lets a run assert
+// against configuration logic without dialing a real provider. The run's
+// mock_provider blocks are overlaid on top of the file's per provider
+// address, the same way run/file variables are merged elsewhere in this
+// file, so a run mocking one provider doesn't lose every other provider the
+// file mocked.
+func mockProviderFactories(run *moduletest.Run, file *moduletest.File) map[addrs.Provider]providers.Factory {
+	configured := overlayByProviderAddr(file.Config.MockProviders, nil)
+	if run != nil {
+		configured = overlayByProviderAddr(configured, run.Config.MockProviders)
+	}
+	if len(configured) == 0 {
+		return nil
+	}
+
+	factories := make(map[addrs.Provider]providers.Factory, len(configured))
+	for addr, mock := range configured {
+		mock := mock // capture the loop variable for the closure below
+		factories[addr] = func() (providers.Interface, error) {
+			return mocking.NewMockProvider(mock), nil
+		}
+	}
+	return factories
+}
+
+// mergeProviderFactories overlays the mocked provider factories on top of
+// the real ones, so mocked providers stand in for their real counterparts
+// while every other provider the configuration needs is still resolved
+// normally.
+func mergeProviderFactories(real, mocked map[addrs.Provider]providers.Factory) map[addrs.Provider]providers.Factory {
+	merged := make(map[addrs.Provider]providers.Factory, len(real)+len(mocked))
+	for addr, factory := range real {
+		merged[addr] = factory
+	}
+	for addr, factory := range mocked {
+		merged[addr] = factory
+	}
+	return merged
+}
+
+// overlayByProviderAddr returns a new map containing every entry of base,
+// with any matching provider address in overlay taking precedence. Used to
+// layer a run's mock_provider blocks on top of its file's, per address,
+// rather than one replacing the other wholesale.
+func overlayByProviderAddr[V any](base, overlay map[addrs.Provider]V) map[addrs.Provider]V {
+	merged := make(map[addrs.Provider]V, len(base)+len(overlay))
+	for addr, v := range base {
+		merged[addr] = v
+	}
+	for addr, v := range overlay {
+		merged[addr] = v
+	}
+	return merged
+}
+
+// runUsesOnlyMockedProviders reports whether every provider required by
+// config is covered by the given set of mocked provider factories, meaning
+// the run touched no real infrastructure at all.
+func runUsesOnlyMockedProviders(config *configs.Config, mocked map[addrs.Provider]providers.Factory) bool {
+	reqs, diags := config.ProviderRequirements()
+	if diags.HasErrors() || reqs == nil {
+		return false
+	}
+	for provider := range reqs.AllProviders() {
+		if _, ok := mocked[provider]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // execute executes Terraform plan and apply operations for the given arguments.
 //
 // The command argument decides whether it executes only a plan or also applies
@@ -493,13 +799,21 @@ func (runner *TestRunner) execute(mgr *TestStateManager, run *moduletest.Run, fi
 
 	// Second, gather any variables and give them to the plan options.
 
-	variables, variableDiags := buildInputVariablesForTest(run, file, config, globals)
+	variables, variableDiags := buildInputVariablesForTest(runner, run, file, config, globals)
 	diags = diags.Append(variableDiags)
 	if variableDiags.HasErrors() {
 		return nil, nil, state, diags
 	}
 	opts.SetVariables = variables
 
+	// If the run block (or, failing that, the file) declared any
+	// override_resource/override_data blocks, resolve them into the
+	// Overrides the plan can use to substitute the given values for the
+	// target's computed attributes (or, with override_computed set, every
+	// attribute), so a run can assert against deterministic values without
+	// waiting on a real provider to produce them.
+	opts.Overrides = mocking.PackageOverrides(run, file, config)
+
 	// Third, execute planning stage.
 
 	tfCtxOpts, err := runner.command.contextOpts()
@@ -508,6 +822,30 @@ func (runner *TestRunner) execute(mgr *TestStateManager, run *moduletest.Run, fi
 		return nil, nil, state, diags
 	}
 
+	// If the run block and/or the file declared any mock_provider blocks
+	// (overlaid per provider address), substitute a synthesized provider factory that
+	// hands back canned values instead of dialing the real provider. This is
+	// what lets a run assert against configuration logic without creating
+	// real infrastructure.
+	mocks := mockProviderFactories(run, file)
+	if len(mocks) > 0 {
+		tfCtxOpts.Providers = mergeProviderFactories(tfCtxOpts.Providers, mocks)
+	}
+	// cleanupStates's destroy pass calls execute with run == nil, so
+	// stateKeyForRun(run) can't recover which named state scope is actually
+	// being destroyed; recording there would silently clobber whatever
+	// happens to be at the "" key instead of the one being torn down. A
+	// destroy call is always in DestroyMode, which a normal run execution
+	// never uses, so that's what distinguishes the two. A module-under-test
+	// run is also excluded: it's tracked via ModuleStates rather than the
+	// named state_key buckets mockedOnly covers, so stateKeyForRun(run)
+	// defaulting to "" for it would otherwise pollute the unrelated main
+	// scope's mocked-only tracking with this run's completely unrelated
+	// infrastructure.
+	if opts.Mode != plans.DestroyMode && run.Config.ConfigUnderTest == nil {
+		mgr.recordMockUsage(stateKeyForRun(run), len(mocks) > 0 && runUsesOnlyMockedProviders(config, mocks))
+	}
+
 	tfCtx, ctxDiags := terraform.NewContext(tfCtxOpts)
 	diags = diags.Append(ctxDiags)
 	if ctxDiags.HasErrors() {
@@ -605,11 +943,11 @@ func (runner *TestRunner) wait(ctx *terraform.Context, runningCtx context.Contex
 	handleCancelled := func() {
 
 		states := make(map[*moduletest.Run]*states.State)
-		states[nil] = mgr.State
-		for _, module := range mgr.States {
+		states[nil] = mgr.stateForKey("")
+		for _, module := range mgr.ModuleStates {
 			states[module.Run] = module.State
 		}
-		runner.View.FatalInterruptSummary(run, file, states, created)
+		mgr.view.FatalInterruptSummary(run, file, states, created)
 
 		cancelled = true
 		go ctx.Stop()
@@ -648,6 +986,66 @@ func (runner *TestRunner) wait(ctx *terraform.Context, runningCtx context.Contex
 	return diags, cancelled
 }
 
+// bufferedTestView wraps a views.Test and records the calls made while a
+// single test file executes, instead of forwarding them immediately. This
+// lets several files run in parallel without their output interleaving on
+// the terminal: each worker reports through its own bufferedTestView, and
+// once its file is finished the caller flushes the recording to the real,
+// shared view.
+type bufferedTestView struct {
+	views.Test
+
+	mu     sync.Mutex
+	replay []func(views.Test)
+}
+
+func newBufferedTestView(real views.Test) *bufferedTestView {
+	return &bufferedTestView{Test: real}
+}
+
+func (v *bufferedTestView) File(file *moduletest.File) {
+	v.record(func(real views.Test) { real.File(file) })
+}
+
+func (v *bufferedTestView) Run(run *moduletest.Run, file *moduletest.File) {
+	v.record(func(real views.Test) { real.Run(run, file) })
+}
+
+func (v *bufferedTestView) Diagnostics(run *moduletest.Run, file *moduletest.File, diags tfdiags.Diagnostics) {
+	v.record(func(real views.Test) { real.Diagnostics(run, file, diags) })
+}
+
+func (v *bufferedTestView) DestroySummary(diags tfdiags.Diagnostics, run *moduletest.Run, file *moduletest.File, state *states.State) {
+	v.record(func(real views.Test) { real.DestroySummary(diags, run, file, state) })
+}
+
+// FatalInterruptSummary is deliberately not buffered: a fatal interrupt
+// means the user asked us to stop right now, so they need to see what
+// infrastructure was left behind immediately rather than waiting on a
+// flush that a hard-cancelled worker may never reach.
+func (v *bufferedTestView) FatalInterruptSummary(run *moduletest.Run, file *moduletest.File, states map[*moduletest.Run]*states.State, created []*plans.ResourceInstanceChangeSrc) {
+	v.Test.FatalInterruptSummary(run, file, states, created)
+}
+
+func (v *bufferedTestView) record(fn func(views.Test)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.replay = append(v.replay, fn)
+}
+
+// Flush replays every buffered call, in the order it was recorded, against
+// the real view.
+func (v *bufferedTestView) Flush() {
+	v.mu.Lock()
+	replay := v.replay
+	v.replay = nil
+	v.mu.Unlock()
+
+	for _, fn := range replay {
+		fn(v.Test)
+	}
+}
+
 // state management
 
 // TestStateManager is a helper struct to maintain the various state objects
@@ -655,17 +1053,103 @@ func (runner *TestRunner) wait(ctx *terraform.Context, runningCtx context.Contex
 type TestStateManager struct {
 	runner *TestRunner
 
-	// State is the main state of the module under test during a single test
-	// file execution. This state will be updated by every run block without
-	// a modifier module block within the test file. At the end of the test
-	// file's execution everything in this state should be executed.
-	State *states.State
+	// Config is the root configuration this manager's file is executing
+	// against. It's carried here, rather than read off runner.Config
+	// directly, for the same reason as view below: a file running as part
+	// of a parallel batch executes against its own independently loaded
+	// config rather than the runner's shared one.
+	Config *configs.Config
 
-	// States contains the states of every run block within a test file that
-	// executed using an alternative module. Any resources created by these
-	// run blocks also need to be tidied up, but only after the main state file
-	// has been handled.
-	States []*TestModuleState
+	// view is the output stream this file's results are reported to. It's
+	// carried on the manager, rather than read off runner.View directly, so
+	// that a file running as part of a parallel batch can report through its
+	// own buffered view instead of the runner's shared one.
+	view views.Test
+
+	// states holds the state for every named state scope in use during a
+	// single test file execution, keyed by the state_key of the run blocks
+	// that populate it. The main, unnamed scope is keyed by "", matching the
+	// convention OpenTofu's test runner uses for the same purpose. Each of
+	// these states is updated by every run block that targets it, and at the
+	// end of the test file's execution everything in them should be
+	// destroyed.
+	states map[string]*states.State
+
+	// order records the sequence in which state keys were first populated,
+	// so that cleanupStates can destroy them in reverse: a state scope
+	// created after another may have been built to depend on it (e.g. a
+	// "compute" scope referencing a "network" scope), so it must be torn
+	// down first.
+	order []string
+
+	// ModuleStates contains the states of every run block within a test file
+	// that executed using an alternative module. Any resources created by
+	// these run blocks also need to be tidied up, but only after the named
+	// state scopes above have been handled.
+	ModuleStates []*TestModuleState
+
+	// mockedOnly tracks, per state key, whether every run block that has
+	// populated that scope so far used only mocked providers. A scope that
+	// stays true never touched a real provider, so cleanupStates can skip
+	// destroying it entirely.
+	mockedOnly map[string]bool
+}
+
+// recordMockUsage notes whether a run block that just executed against the
+// given state key touched only mocked providers. A single real-provider run
+// against a key is enough to mark that key as needing a real destroy.
+func (manager *TestStateManager) recordMockUsage(key string, onlyMocked bool) {
+	if manager.mockedOnly == nil {
+		manager.mockedOnly = make(map[string]bool)
+	}
+	if _, seen := manager.mockedOnly[key]; !seen {
+		manager.mockedOnly[key] = onlyMocked
+		return
+	}
+	manager.mockedOnly[key] = manager.mockedOnly[key] && onlyMocked
+}
+
+// newTestStateManager returns a TestStateManager with no state scopes
+// populated yet; scopes are created lazily as run blocks reference them.
+func newTestStateManager(runner *TestRunner, view views.Test, config *configs.Config) *TestStateManager {
+	return &TestStateManager{
+		runner: runner,
+		Config: config,
+		view:   view,
+		states: make(map[string]*states.State),
+	}
+}
+
+// stateForKey returns the state for the given state_key, creating a fresh
+// empty state the first time the key is seen and recording it in order so
+// cleanupStates can destroy it in the right sequence.
+func (manager *TestStateManager) stateForKey(key string) *states.State {
+	state, exists := manager.states[key]
+	if !exists {
+		state = states.NewState()
+		manager.states[key] = state
+		manager.order = append(manager.order, key)
+	}
+	return state
+}
+
+// setStateForKey records the state that resulted from a run block executing
+// against the given state_key.
+func (manager *TestStateManager) setStateForKey(key string, state *states.State) {
+	if _, exists := manager.states[key]; !exists {
+		manager.order = append(manager.order, key)
+	}
+	manager.states[key] = state
+}
+
+// stateKeyForRun returns the state_key a run block targets, defaulting to
+// the empty string (the file's main, unnamed state) when the run block
+// didn't specify one.
+func stateKeyForRun(run *moduletest.Run) string {
+	if run == nil || len(run.Config.StateKey) == 0 {
+		return ""
+	}
+	return run.Config.StateKey
 }
 
 // TestModuleState holds the config and the state for a given run block that
@@ -685,11 +1169,27 @@ func (manager *TestStateManager) cleanupStates(file *moduletest.File, globals ma
 		return
 	}
 
-	// First, we'll clean up the main state.
-	_, _, state, diags := manager.runner.execute(manager, nil, file, manager.runner.Config, manager.State, &terraform.PlanOpts{
-		Mode: plans.DestroyMode,
-	}, configs.ApplyTestCommand, globals)
-	manager.runner.View.DestroySummary(diags, nil, file, state)
+	// First, we'll clean up the named state scopes, in reverse of the order
+	// they were first populated in.
+	for ix := len(manager.order) - 1; ix >= 0; ix-- {
+		if manager.runner.Cancelled {
+			// In case cancellation came while a previous scope was being
+			// destroyed.
+			return
+		}
+
+		key := manager.order[ix]
+		if manager.mockedOnly[key] {
+			// Every run block that touched this state used only mocked
+			// providers, so there's no real infrastructure to tear down.
+			continue
+		}
+
+		_, _, state, diags := manager.runner.execute(manager, nil, file, manager.Config, manager.states[key], &terraform.PlanOpts{
+			Mode: plans.DestroyMode,
+		}, configs.ApplyTestCommand, globals)
+		manager.view.DestroySummary(diags, nil, file, state)
+	}
 
 	if manager.runner.Cancelled {
 		// In case things were cancelled during the last execution.
@@ -698,8 +1198,8 @@ func (manager *TestStateManager) cleanupStates(file *moduletest.File, globals ma
 
 	// Then we'll clean up the additional states for custom modules in reverse
 	// order.
-	for ix := len(manager.States); ix > 0; ix-- {
-		module := manager.States[ix-1]
+	for ix := len(manager.ModuleStates); ix > 0; ix-- {
+		module := manager.ModuleStates[ix-1]
 
 		if manager.runner.Cancelled {
 			// In case the cancellation came while a previous state was being
@@ -710,19 +1210,231 @@ func (manager *TestStateManager) cleanupStates(file *moduletest.File, globals ma
 		_, _, state, diags := manager.runner.execute(manager, module.Run, file, module.Run.Config.ConfigUnderTest, module.State, &terraform.PlanOpts{
 			Mode: plans.DestroyMode,
 		}, configs.ApplyTestCommand, globals)
-		manager.runner.View.DestroySummary(diags, module.Run, file, state)
+		manager.view.DestroySummary(diags, module.Run, file, state)
 	}
 }
 
 // helper functions
 
+// testVariableLayers resolves (and caches, per test file) the variables
+// auto-loaded from tfvars files next to the test file, so every run block
+// within it shares one parse of those files rather than repeating the work
+// per run. TF_VAR_ environment variables aren't handled here: they're
+// already folded into globals by collectVariableValues, the same as for
+// `plan`/`apply`.
+func (runner *TestRunner) testVariableLayers(file *moduletest.File) (map[string]backend.UnparsedVariableValue, tfdiags.Diagnostics) {
+	runner.variableLayersMu.Lock()
+	defer runner.variableLayersMu.Unlock()
+
+	if runner.variableLayers == nil {
+		runner.variableLayers = make(map[string]map[string]backend.UnparsedVariableValue)
+	}
+	if files, exists := runner.variableLayers[file.Name]; exists {
+		return files, nil
+	}
+
+	files, diags := loadAutoTestVariableFiles(runner.TestDirectory, file.Config.VariablesFiles)
+	runner.variableLayers[file.Name] = files
+	return files, diags
+}
+
+// loadAutoTestVariableFiles parses terraform.tfvars/*.auto.tfvars (and their
+// .json equivalents) found directly inside testDirectory, plus any paths
+// named in a test file's variables_files attribute, into a single layer of
+// UnparsedVariableValue, exactly as terraform plan/apply auto-load tfvars
+// next to the root module.
+func loadAutoTestVariableFiles(testDirectory string, variablesFiles []string) (map[string]backend.UnparsedVariableValue, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	variables := make(map[string]backend.UnparsedVariableValue)
+
+	// terraform.tfvars (then terraform.tfvars.json) is loaded first and the
+	// *.auto.tfvars files after it in lexical order, so later files win.
+	var paths, autoPaths []string
+	if entries, err := os.ReadDir(testDirectory); err == nil {
+		for _, entry := range entries {
+			name := entry.Name()
+			if name == "terraform.tfvars" || name == "terraform.tfvars.json" {
+				paths = append(paths, filepath.Join(testDirectory, name))
+			} else if strings.HasSuffix(name, ".auto.tfvars") || strings.HasSuffix(name, ".auto.tfvars.json") {
+				autoPaths = append(autoPaths, filepath.Join(testDirectory, name))
+			}
+		}
+		sort.Strings(paths)
+		sort.Strings(autoPaths)
+		paths = append(paths, autoPaths...)
+	}
+	for _, extra := range variablesFiles {
+		paths = append(paths, filepath.Join(testDirectory, extra))
+	}
+
+	parser := hclparse.NewParser()
+	for _, path := range paths {
+		var file *hcl.File
+		var fileDiags hcl.Diagnostics
+		if strings.HasSuffix(path, ".json") {
+			file, fileDiags = parser.ParseJSONFile(path)
+		} else {
+			file, fileDiags = parser.ParseHCLFile(path)
+		}
+		diags = diags.Append(fileDiags)
+		if fileDiags.HasErrors() {
+			continue
+		}
+
+		attrs, attrDiags := file.Body.JustAttributes()
+		diags = diags.Append(attrDiags)
+		for name, attr := range attrs {
+			variables[name] = unparsedVariableValueExpression{
+				expr:       attr.Expr,
+				sourceType: terraform.ValueFromNamedFile,
+			}
+		}
+	}
+
+	return variables, diags
+}
+
+// unparsedVariableValueJSON implements backend.UnparsedVariableValue for a
+// value that arrived as raw JSON, either from a run/file block's
+// variables_json attribute or a -test-var-file. It always parses with
+// configs.VariableParseHCL, regardless of the variable's own parsing mode:
+// JSON already encodes a typed value (including null and nested objects),
+// so re-interpreting it as a literal string the way -var does would lose
+// that structure.
+type unparsedVariableValueJSON struct {
+	source string // for diagnostics: the file path, or the run/file block that declared it
+	name   string
+	raw    json.RawMessage
+}
+
+func (v unparsedVariableValueJSON) ParseVariableValue(configs.VariableParsingMode) (*terraform.InputValue, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	val, valDiags := configs.VariableParseHCL.Parse(v.name, string(v.raw))
+	diags = diags.Append(valDiags)
+	if diags.HasErrors() {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid JSON variable value",
+			fmt.Sprintf("The value for %q in %s could not be parsed.", v.name, v.source),
+		))
+		return nil, diags
+	}
+	return &terraform.InputValue{
+		Value:      val,
+		SourceType: terraform.ValueFromTestJSON,
+	}, diags
+}
+
+// parseVariablesJSON decodes a JSON object of variable values, as found in
+// a run/file block's variables_json attribute or a -test-var-file, into a
+// layer of UnparsedVariableValue. source identifies where the JSON came
+// from, for diagnostics.
+func parseVariablesJSON(source string, raw []byte) (map[string]backend.UnparsedVariableValue, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	if len(raw) == 0 {
+		return nil, diags
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid JSON variables",
+			fmt.Sprintf("Failed to parse %s as a JSON object of variable values: %s.", source, err),
+		))
+		return nil, diags
+	}
+
+	variables := make(map[string]backend.UnparsedVariableValue, len(fields))
+	for name, value := range fields {
+		variables[name] = unparsedVariableValueJSON{
+			source: source,
+			name:   name,
+			raw:    value,
+		}
+	}
+	return variables, diags
+}
+
+// mergeTestVarFileVariables layers the variables parsed from a single
+// -test-var-file into variables, skipping any name in protected (the
+// -var/-var-file globals, which always take precedence) but otherwise
+// overwriting, so that of multiple -test-var-file paths given on the
+// command line the last one wins, matching -var-file's own convention.
+func mergeTestVarFileVariables(variables map[string]backend.UnparsedVariableValue, protected map[string]struct{}, fileVariables map[string]backend.UnparsedVariableValue) {
+	for name, variable := range fileVariables {
+		if _, exists := protected[name]; exists {
+			continue
+		}
+		variables[name] = variable
+	}
+}
+
+// jsonVariablesForRun decodes the variables_json attribute of a run block,
+// if it set one.
+func jsonVariablesForRun(run *moduletest.Run) (map[string]backend.UnparsedVariableValue, tfdiags.Diagnostics) {
+	if run == nil || len(run.Config.VariablesJSON) == 0 {
+		return nil, nil
+	}
+	return parseVariablesJSON(fmt.Sprintf("variables_json in run %q", run.Name), []byte(run.Config.VariablesJSON))
+}
+
+// jsonVariablesForFile decodes the variables_json attribute of a test file
+// block, if it set one.
+func jsonVariablesForFile(file *moduletest.File) (map[string]backend.UnparsedVariableValue, tfdiags.Diagnostics) {
+	if file == nil || len(file.Config.VariablesJSON) == 0 {
+		return nil, nil
+	}
+	return parseVariablesJSON(fmt.Sprintf("variables_json in file %q", file.Name), []byte(file.Config.VariablesJSON))
+}
+
+// splitGlobalsFromEnv separates globals, the already-resolved
+// `-var`/`-var-file` layer that c.collectVariableValues returns, from any
+// entries in it that are only there because of a TF_VAR_ environment
+// variable. collectVariableValues folds TF_VAR_* in at the lowest priority
+// of its own flat map, but the test runner's precedence puts TF_VAR_*
+// below the auto-loaded test-directory tfvars layer, not above it, so
+// those entries need to be pulled out and checked separately, below that
+// layer, rather than alongside the real -var/-var-file values.
+func splitGlobalsFromEnv(globals map[string]backend.UnparsedVariableValue) (cliGlobals, envGlobals map[string]backend.UnparsedVariableValue) {
+	cliGlobals = make(map[string]backend.UnparsedVariableValue, len(globals))
+	envGlobals = make(map[string]backend.UnparsedVariableValue)
+
+	for name, variable := range globals {
+		// A -var/-var-file value for the same name replaces the TF_VAR_
+		// entry in globals, so only entries still sourced from the
+		// environment are moved down.
+		if v, ok := variable.(unparsedVariableValueString); ok && v.sourceType == terraform.ValueFromEnvVar {
+			envGlobals[name] = variable
+			continue
+		}
+		cliGlobals[name] = variable
+	}
+
+	return cliGlobals, envGlobals
+}
+
 // buildInputVariablesForTest creates a terraform.InputValues mapping for
 // variable values that are relevant to the config being tested.
 //
 // Crucially, it differs from buildInputVariablesForAssertions in that it only
 // includes variables that are reference by the config and not everything that
 // is defined within the test run block and test file.
-func buildInputVariablesForTest(run *moduletest.Run, file *moduletest.File, config *configs.Config, globals map[string]backend.UnparsedVariableValue) (terraform.InputValues, tfdiags.Diagnostics) {
+//
+// The full precedence order, highest first: run block HCL variables, file
+// block HCL variables, run block `variables_json`, file block
+// `variables_json`, `-var`/`-var-file`/`-test-var-file` globals, auto-loaded
+// tfvars files next to the test file (plus any named in the file's
+// `variables_files` attribute), and finally TF_VAR_ environment variables.
+func buildInputVariablesForTest(runner *TestRunner, run *moduletest.Run, file *moduletest.File, config *configs.Config, globals map[string]backend.UnparsedVariableValue) (terraform.InputValues, tfdiags.Diagnostics) {
+	files, diags := runner.testVariableLayers(file)
+	cliGlobals, envGlobals := splitGlobalsFromEnv(globals)
+
+	runJSON, runJSONDiags := jsonVariablesForRun(run)
+	diags = diags.Append(runJSONDiags)
+	fileJSON, fileJSONDiags := jsonVariablesForFile(file)
+	diags = diags.Append(fileJSONDiags)
+
 	variables := make(map[string]backend.UnparsedVariableValue)
 	for name := range config.Module.Variables {
 		if run != nil {
@@ -747,19 +1459,47 @@ func buildInputVariablesForTest(run *moduletest.Run, file *moduletest.File, conf
 			}
 		}
 
-		if globals != nil {
+		if variable, exists := runJSON[name]; exists {
+			// variables_json is the structured counterpart to the HCL
+			// attributes above: it's consulted after both the run's and the
+			// file's HCL variables, since HCL declared directly beats a
+			// value that arrived as JSON, but still ahead of the global and
+			// auto-loaded layers below.
+			variables[name] = variable
+			continue
+		}
+
+		if variable, exists := fileJSON[name]; exists {
+			variables[name] = variable
+			continue
+		}
+
+		if variable, exists := cliGlobals[name]; exists {
 			// If it's not set locally or at the file level, maybe it was
-			// defined globally.
-			if variable, exists := globals[name]; exists {
-				variables[name] = variable
-			}
+			// defined globally via -var/-var-file.
+			variables[name] = variable
+			continue
+		}
+
+		if variable, exists := files[name]; exists {
+			// Next, check the tfvars files auto-loaded from next to the
+			// test file (and any named in variables_files).
+			variables[name] = variable
+			continue
+		}
+
+		if variable, exists := envGlobals[name]; exists {
+			// Finally, fall back to a TF_VAR_ environment variable.
+			variables[name] = variable
 		}
 
 		// If it's not set at all that might be okay if the variable is optional
 		// so we'll just not add anything to the map.
 	}
 
-	return backend.ParseVariableValues(variables, config.Module.Variables)
+	values, valuesDiags := backend.ParseVariableValues(variables, config.Module.Variables)
+	diags = diags.Append(valuesDiags)
+	return values, diags
 }
 
 // buildInputVariablesForAssertions creates a terraform.InputValues mapping that
@@ -774,7 +1514,10 @@ func buildInputVariablesForTest(run *moduletest.Run, file *moduletest.File, conf
 // defined within the config. We might want to remove these warnings in the
 // future, since it is actually okay for test files to have variables defined
 // outside the configuration.
-func buildInputVariablesForAssertions(run *moduletest.Run, file *moduletest.File, config *configs.Config, globals map[string]backend.UnparsedVariableValue) (terraform.InputValues, tfdiags.Diagnostics) {
+func buildInputVariablesForAssertions(runner *TestRunner, run *moduletest.Run, file *moduletest.File, config *configs.Config, globals map[string]backend.UnparsedVariableValue) (terraform.InputValues, tfdiags.Diagnostics) {
+	files, diags := runner.testVariableLayers(file)
+	cliGlobals, envGlobals := splitGlobalsFromEnv(globals)
+
 	variables := make(map[string]backend.UnparsedVariableValue)
 
 	if run != nil {
@@ -800,7 +1543,25 @@ func buildInputVariablesForAssertions(run *moduletest.Run, file *moduletest.File
 		}
 	}
 
-	for name, variable := range globals {
+	runJSON, runJSONDiags := jsonVariablesForRun(run)
+	diags = diags.Append(runJSONDiags)
+	for name, variable := range runJSON {
+		if _, exists := variables[name]; exists {
+			continue
+		}
+		variables[name] = variable
+	}
+
+	fileJSON, fileJSONDiags := jsonVariablesForFile(file)
+	diags = diags.Append(fileJSONDiags)
+	for name, variable := range fileJSON {
+		if _, exists := variables[name]; exists {
+			continue
+		}
+		variables[name] = variable
+	}
+
+	for name, variable := range cliGlobals {
 		if _, exists := variables[name]; exists {
 			// Then this value was already defined at either the run level
 			// or the file level, and we want those values to take
@@ -810,5 +1571,28 @@ func buildInputVariablesForAssertions(run *moduletest.Run, file *moduletest.File
 		variables[name] = variable
 	}
 
-	return backend.ParseVariableValues(variables, config.Module.Variables)
+	for name, variable := range files {
+		// Next, values from tfvars files auto-loaded next to the test file.
+		if _, exists := variables[name]; exists {
+			continue
+		}
+		variables[name] = variable
+	}
+
+	for name, variable := range envGlobals {
+		if _, exists := variables[name]; exists {
+			continue
+		}
+		if _, declared := config.Module.Variables[name]; !declared {
+			// Mirror `terraform plan`, which silently ignores TF_VAR_ values
+			// for variables the configuration doesn't declare, rather than
+			// warning about every unrelated TF_VAR_ the user's shell has set.
+			continue
+		}
+		variables[name] = variable
+	}
+
+	values, valuesDiags := backend.ParseVariableValues(variables, config.Module.Variables)
+	diags = diags.Append(valuesDiags)
+	return values, diags
 }
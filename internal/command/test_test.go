@@ -0,0 +1,196 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/command/arguments"
+	"github.com/hashicorp/terraform/internal/backend"
+	"github.com/hashicorp/terraform/internal/configs"
+	"github.com/hashicorp/terraform/internal/states"
+	"github.com/hashicorp/terraform/internal/terraform"
+	"github.com/hashicorp/terraform/internal/tfdiags"
+)
+
+// fakeUnparsedVariableValue is a minimal backend.UnparsedVariableValue for
+// tests that only need to tell distinct values apart, not actually parse
+// them.
+type fakeUnparsedVariableValue string
+
+func (v fakeUnparsedVariableValue) ParseVariableValue(configs.VariableParsingMode) (*terraform.InputValue, tfdiags.Diagnostics) {
+	return nil, nil
+}
+
+// newTestStateManager must carry the config it was given rather than
+// falling back to runner.Config, since a file executing as part of a
+// parallel batch is given its own independently loaded config precisely so
+// its execute() calls don't mutate the same *configs.Config another file is
+// concurrently planning/applying against.
+func TestNewTestStateManager_UsesGivenConfig(t *testing.T) {
+	runnerConfig := &configs.Config{}
+	fileConfig := &configs.Config{}
+
+	runner := &TestRunner{Config: runnerConfig}
+	mgr := newTestStateManager(runner, nil, fileConfig)
+
+	if mgr.Config != fileConfig {
+		t.Fatalf("expected manager to use the config it was given, got a different config")
+	}
+	if mgr.Config == runner.Config {
+		t.Fatalf("expected manager's config to be independent of runner.Config")
+	}
+}
+
+// recordMockUsage must only ever see onlyMocked=false downgrade a key to
+// "needs a real destroy" and never the other way around: once a key has
+// touched a real provider it stays that way regardless of what order run
+// blocks populate it in.
+func TestTestStateManager_RecordMockUsage(t *testing.T) {
+	mgr := &TestStateManager{}
+
+	mgr.recordMockUsage("network", true)
+	if !mgr.mockedOnly["network"] {
+		t.Fatalf("expected network to start out mocked-only")
+	}
+
+	mgr.recordMockUsage("network", false)
+	if mgr.mockedOnly["network"] {
+		t.Fatalf("a single real-provider run should mark the key as needing a real destroy")
+	}
+
+	mgr.recordMockUsage("network", true)
+	if mgr.mockedOnly["network"] {
+		t.Fatalf("a later mocked-only run must not undo an earlier real-provider run")
+	}
+}
+
+// mergeTestVarFileVariables must let a later -test-var-file win over an
+// earlier one, but never override a -var/-var-file global.
+func TestMergeTestVarFileVariables(t *testing.T) {
+	variables := map[string]backend.UnparsedVariableValue{
+		"from_global": fakeUnparsedVariableValue("global"),
+	}
+	protected := map[string]struct{}{
+		"from_global": {},
+	}
+
+	mergeTestVarFileVariables(variables, protected, map[string]backend.UnparsedVariableValue{
+		"shared":      fakeUnparsedVariableValue("first-file"),
+		"from_global": fakeUnparsedVariableValue("first-file"),
+	})
+	mergeTestVarFileVariables(variables, protected, map[string]backend.UnparsedVariableValue{
+		"shared": fakeUnparsedVariableValue("second-file"),
+	})
+
+	if got := variables["shared"]; got != fakeUnparsedVariableValue("second-file") {
+		t.Fatalf("expected the later -test-var-file to win, got %v", got)
+	}
+	if got := variables["from_global"]; got != fakeUnparsedVariableValue("global") {
+		t.Fatalf("expected the -var/-var-file global to take precedence, got %v", got)
+	}
+}
+
+// stateForKey and setStateForKey must record each newly seen key in order
+// exactly once, and leave that order untouched on every later call, so
+// cleanupStates tears scopes down in the reverse of the sequence they were
+// first populated in.
+func TestTestStateManager_Order(t *testing.T) {
+	manager := newTestStateManager(&TestRunner{}, nil, &configs.Config{})
+
+	manager.stateForKey("network")
+	manager.stateForKey("compute")
+	manager.setStateForKey("network", states.NewState())
+	manager.setStateForKey("storage", states.NewState())
+	manager.stateForKey("compute")
+
+	want := []string{"network", "compute", "storage"}
+	if len(manager.order) != len(want) {
+		t.Fatalf("expected order %v, got %v", want, manager.order)
+	}
+	for i, key := range want {
+		if manager.order[i] != key {
+			t.Fatalf("expected order %v, got %v", want, manager.order)
+		}
+	}
+}
+
+// overlayByProviderAddr must keep every base entry whose address isn't
+// overridden, but let overlay win wherever the two share an address.
+func TestOverlayByProviderAddr(t *testing.T) {
+	aws := addrs.Provider{Type: "aws"}
+	google := addrs.Provider{Type: "google"}
+
+	base := map[addrs.Provider]int{
+		aws:    1,
+		google: 2,
+	}
+	overlay := map[addrs.Provider]int{
+		aws: 3,
+	}
+
+	merged := overlayByProviderAddr(base, overlay)
+
+	if got := merged[aws]; got != 3 {
+		t.Fatalf("expected overlay to win for %v, got %d", aws, got)
+	}
+	if got := merged[google]; got != 2 {
+		t.Fatalf("expected base entry for %v to survive, got %d", google, got)
+	}
+	if len(base) != 2 || base[aws] != 1 {
+		t.Fatalf("overlayByProviderAddr must not mutate base, got %v", base)
+	}
+}
+
+// validateTestOutputOptions must reject -tap combined with -json or
+// -junit-xml, but allow -junit-xml and -json to be combined with each
+// other.
+func TestValidateTestOutputOptions(t *testing.T) {
+	tests := map[string]struct {
+		viewType     arguments.ViewType
+		tap          bool
+		junitXMLFile string
+		wantErr      bool
+	}{
+		"tap alone":          {viewType: arguments.ViewHuman, tap: true, wantErr: false},
+		"tap and json":       {viewType: arguments.ViewJSON, tap: true, wantErr: true},
+		"tap and junit-xml":  {viewType: arguments.ViewHuman, tap: true, junitXMLFile: "out.xml", wantErr: true},
+		"json and junit-xml": {viewType: arguments.ViewJSON, junitXMLFile: "out.xml", wantErr: false},
+		"json alone":         {viewType: arguments.ViewJSON, wantErr: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			diags := validateTestOutputOptions(test.viewType, test.tap, test.junitXMLFile)
+			if got := diags.HasErrors(); got != test.wantErr {
+				t.Fatalf("expected HasErrors()=%v, got %v (%s)", test.wantErr, got, diags.Err())
+			}
+		})
+	}
+}
+
+// applyExpectedDiagnostics must call validateFailures before
+// validateWarnings, and return whatever validateWarnings produces, so that
+// a diagnostic already claimed as an expected failure can't also be
+// flagged as an unexpected warning.
+func TestApplyExpectedDiagnostics(t *testing.T) {
+	var calls []string
+
+	diags := applyExpectedDiagnostics(
+		nil,
+		func(diags tfdiags.Diagnostics) tfdiags.Diagnostics {
+			calls = append(calls, "failures")
+			return diags
+		},
+		func(diags tfdiags.Diagnostics) tfdiags.Diagnostics {
+			calls = append(calls, "warnings")
+			return diags.Append(tfdiags.Sourceless(tfdiags.Error, "from warnings", ""))
+		},
+	)
+
+	if len(calls) != 2 || calls[0] != "failures" || calls[1] != "warnings" {
+		t.Fatalf("expected failures to be validated before warnings, got %v", calls)
+	}
+	if !diags.HasErrors() {
+		t.Fatalf("expected the result of validateWarnings to be returned")
+	}
+}